@@ -1,6 +1,8 @@
 package build
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -15,7 +17,9 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
@@ -27,6 +31,7 @@ import (
 	v1lister "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 
 	buildv1 "github.com/openshift/api/build/v1"
@@ -44,6 +49,7 @@ import (
 	"github.com/openshift/origin/pkg/build/controller/common"
 	"github.com/openshift/origin/pkg/build/controller/policy"
 	"github.com/openshift/origin/pkg/build/controller/strategy"
+	"github.com/openshift/origin/pkg/build/strategy/buildkit"
 	buildutil "github.com/openshift/origin/pkg/build/util"
 	imageapi "github.com/openshift/origin/pkg/image/apis/image"
 	imageutil "github.com/openshift/origin/pkg/image/util"
@@ -54,6 +60,14 @@ const (
 
 	// maxExcerptLength is the maximum length of the LogSnippet on a build.
 	maxExcerptLength = 5
+
+	// buildPodDeletionGracePeriodSeconds is the grace period given to a build
+	// pod when it is deleted because its build exceeded its execution deadline.
+	buildPodDeletionGracePeriodSeconds = 30
+
+	// defaultImageResolutionTimeout bounds how long resolveImageReferencesWithTimeout
+	// will wait on a cold imageStreamStore before giving up and requeuing.
+	defaultImageResolutionTimeout = 5 * time.Second
 )
 
 // resourceTriggerQueue tracks a set of resource keys to trigger when another object changes.
@@ -147,14 +161,30 @@ type BuildController struct {
 	secretStoreSynced      func() bool
 	imageStreamStoreSynced func() bool
 
-	runPolicies    []policy.RunPolicy
-	createStrategy buildPodCreationStrategy
-	buildDefaults  builddefaults.BuildDefaults
-	buildOverrides buildoverrides.BuildOverrides
+	runPolicies      []policy.RunPolicy
+	createStrategy   buildPodCreationStrategy
+	buildKitStrategy *buildkit.BuildKitStrategy
+	buildDefaults    builddefaults.BuildDefaults
+	buildOverrides   buildoverrides.BuildOverrides
+
+	podTracker *PodTracker
+
+	cancelLock  sync.Mutex
+	cancelFuncs map[string]*buildCancelContext
+
+	imageResolutionTimeout time.Duration
 
-	recorder                record.EventRecorder
-	additionalTrustedCAPath string
-	additionalTrustedCAData []byte
+	recorder                   record.EventRecorder
+	additionalTrustedCAPath    string
+	additionalTrustedCALock    sync.Mutex
+	additionalTrustedCAData    []byte
+	additionalTrustedCAModTime time.Time
+
+	// fetchLogTailOnFailure controls whether setBuildCompletionData falls
+	// back to a live pod log fetch when a failed build's termination
+	// message is empty. Enabled by default; set from the negated
+	// BuildControllerParams.DisableLogTailFetch.
+	fetchLogTailOnFailure bool
 }
 
 // BuildControllerParams is the set of parameters needed to
@@ -164,15 +194,22 @@ type BuildControllerParams struct {
 	BuildConfigInformer     buildv1informer.BuildConfigInformer
 	ImageStreamInformer     imagev1informer.ImageStreamInformer
 	PodInformer             kubeinformers.PodInformer
+	EventInformer           kubeinformers.EventInformer
 	SecretInformer          kubeinformers.SecretInformer
 	KubeClient              kubernetes.Interface
 	BuildClient             buildv1client.Interface
 	DockerBuildStrategy     *strategy.DockerBuildStrategy
 	SourceBuildStrategy     *strategy.SourceBuildStrategy
 	CustomBuildStrategy     *strategy.CustomBuildStrategy
+	BuildKitStrategy        *buildkit.BuildKitStrategy
 	BuildDefaults           builddefaults.BuildDefaults
 	BuildOverrides          buildoverrides.BuildOverrides
 	AdditionalTrustedCAPath string
+
+	// DisableLogTailFetch turns off the fallback live pod log fetch
+	// setBuildCompletionData otherwise performs when a failed build's
+	// termination message is empty.
+	DisableLogTailFetch bool
 }
 
 // NewBuildController creates a new BuildController.
@@ -202,16 +239,22 @@ func NewBuildController(params *BuildControllerParams) *BuildController {
 			sourceBuildStrategy: params.SourceBuildStrategy,
 			customBuildStrategy: params.CustomBuildStrategy,
 		},
-		buildDefaults:  params.BuildDefaults,
-		buildOverrides: params.BuildOverrides,
+		buildKitStrategy: params.BuildKitStrategy,
+		buildDefaults:    params.BuildDefaults,
+		buildOverrides:   params.BuildOverrides,
 
 		buildQueue:       workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
 		imageStreamQueue: newResourceTriggerQueue(),
 		buildConfigQueue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
 
+		cancelFuncs: make(map[string]*buildCancelContext),
+
+		imageResolutionTimeout: defaultImageResolutionTimeout,
+
 		recorder:                eventBroadcaster.NewRecorder(buildscheme.EncoderScheme, corev1.EventSource{Component: "build-controller"}),
 		runPolicies:             policy.GetAllRunPolicies(buildLister, buildClient),
 		additionalTrustedCAPath: params.AdditionalTrustedCAPath,
+		fetchLogTailOnFailure:   !params.DisableLogTailFetch,
 	}
 
 	c.podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -233,6 +276,8 @@ func NewBuildController(params *BuildControllerParams) *BuildController {
 	c.secretStoreSynced = params.SecretInformer.Informer().HasSynced
 	c.imageStreamStoreSynced = params.ImageStreamInformer.Informer().HasSynced
 
+	c.podTracker = NewPodTracker(params.EventInformer, c.podStore, c.podStoreSynced, c.buildQueue)
+
 	return c
 }
 
@@ -249,6 +294,10 @@ func (bc *BuildController) Run(workers int, stopCh <-chan struct{}) {
 			glog.Warningf("Failed to read additional CA bundle %s: %v", bc.additionalTrustedCAPath, err)
 		}
 		bc.additionalTrustedCAData = caData
+		if info, err := os.Stat(bc.additionalTrustedCAPath); err == nil {
+			bc.additionalTrustedCAModTime = info.ModTime()
+		}
+		go wait.Until(bc.watchAdditionalTrustedCA, caWatchPollInterval, stopCh)
 	}
 
 	// Wait for the controller stores to sync before starting any work in this controller.
@@ -256,6 +305,10 @@ func (bc *BuildController) Run(workers int, stopCh <-chan struct{}) {
 		utilruntime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
 		return
 	}
+	if !bc.podTracker.WaitForCacheSync(stopCh) {
+		utilruntime.HandleError(fmt.Errorf("timed out waiting for pod tracker caches to sync"))
+		return
+	}
 
 	glog.Infof("Starting build controller")
 
@@ -434,12 +487,26 @@ func shouldCancel(build *buildv1.Build) bool {
 	return !buildutil.IsBuildComplete(build) && build.Status.Cancelled
 }
 
-// cancelBuild deletes a build pod and returns an update to mark the build as cancelled
+// buildCancelGracePeriodSeconds is the grace period given to a build pod
+// when it is actively terminated because its build was cancelled, before
+// cancelBuild falls back to a force delete. A var rather than a const so
+// tests can shrink it instead of waiting out the real grace period.
+var buildCancelGracePeriodSeconds int64 = 10
+
+// cancelBuild actively terminates a build's pod and returns an update to mark
+// the build as cancelled. It signals the per-build context created in
+// createBuildPod (waking the goroutine watching it, in case the pod is still
+// being created or is otherwise not yet visible to this call), then issues a
+// graceful delete with buildCancelGracePeriodSeconds, falling back to an
+// immediate force delete if the pod is still present afterwards.
 func (bc *BuildController) cancelBuild(build *buildv1.Build) (*buildUpdate, error) {
 	glog.V(4).Infof("Cancelling build %s", buildDesc(build))
 
+	bc.cancelBuildContext(build)
+
 	podName := buildapihelpers.GetBuildPodName(build)
-	err := bc.podClient.Pods(build.Namespace).Delete(podName, &metav1.DeleteOptions{})
+	gracePeriod := buildCancelGracePeriodSeconds
+	err := bc.podClient.Pods(build.Namespace).Delete(podName, &metav1.DeleteOptions{GracePeriodSeconds: &gracePeriod})
 	if err != nil && !errors.IsNotFound(err) {
 		return nil, fmt.Errorf("could not delete build pod %s/%s to cancel build %s: %v", build.Namespace, podName, buildDesc(build), err)
 	}
@@ -447,6 +514,87 @@ func (bc *BuildController) cancelBuild(build *buildv1.Build) (*buildUpdate, erro
 	return transitionToPhase(buildv1.BuildPhaseCancelled, buildv1.StatusReasonCancelledBuild, buildutil.StatusMessageCancelledBuild), nil
 }
 
+// buildCancelContext is the per-build state watchForCancellation's goroutine
+// waits on: cancel fires it with intent to force-delete the pod (an actual
+// cancellation), while done fires it with no such intent (the build already
+// reached a terminal phase on its own) so the goroutine can exit without
+// racing a delete against a pod that has already done its job.
+type buildCancelContext struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// watchForCancellation creates a context for the given build's pod and starts
+// a goroutine that force-deletes the pod once the context is cancelled and
+// buildCancelGracePeriodSeconds has passed. The goroutine is woken either by
+// cancelBuild cancelling the context (user/controller-initiated cancellation)
+// or by untrackBuildContext closing done once the build reaches a terminal
+// phase on its own -- whichever happens first wins, so a cancellation that
+// races with a pod that is already completing does not re-delete a pod whose
+// build has already reached a terminal phase.
+func (bc *BuildController) watchForCancellation(build *buildv1.Build, pod *corev1.Pod) {
+	ctx, cancel := context.WithCancel(context.Background())
+	key := resourceName(build.Namespace, build.Name)
+	bcc := &buildCancelContext{cancel: cancel, done: make(chan struct{})}
+
+	bc.cancelLock.Lock()
+	bc.cancelFuncs[key] = bcc
+	bc.cancelLock.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Give cancelBuild's own graceful delete (issued with the same
+			// buildCancelGracePeriodSeconds) a chance to actually terminate
+			// the pod before forcing it: a second Delete on an object
+			// already being deleted pulls deletionGracePeriodSeconds down to
+			// the smaller of the two, so force-deleting immediately here
+			// would silently defeat the grace period on every cancellation,
+			// not just the "pod not yet visible" race this goroutine exists
+			// for.
+			select {
+			case <-time.After(time.Duration(buildCancelGracePeriodSeconds) * time.Second):
+			case <-bcc.done:
+				return
+			}
+			gracePeriod := int64(0)
+			if err := bc.podClient.Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{GracePeriodSeconds: &gracePeriod}); err != nil && !errors.IsNotFound(err) {
+				utilruntime.HandleError(fmt.Errorf("failed to force-delete build pod %s/%s after cancellation: %v", pod.Namespace, pod.Name, err))
+			}
+		case <-bcc.done:
+		}
+	}()
+}
+
+// cancelBuildContext cancels and forgets the context tracked for build, if
+// any, so its watchForCancellation goroutine force-deletes the pod.
+func (bc *BuildController) cancelBuildContext(build *buildv1.Build) {
+	key := resourceName(build.Namespace, build.Name)
+	bc.cancelLock.Lock()
+	bcc, ok := bc.cancelFuncs[key]
+	delete(bc.cancelFuncs, key)
+	bc.cancelLock.Unlock()
+	if ok {
+		bcc.cancel()
+	}
+}
+
+// untrackBuildContext forgets the context tracked for build without
+// cancelling it, used once the build has already reached a terminal phase
+// through its own pod lifecycle: it closes bcc.done so watchForCancellation's
+// goroutine exits without force-deleting the pod, instead of leaking the
+// goroutine (and the cancelFuncs entry) for the life of the process.
+func (bc *BuildController) untrackBuildContext(build *buildv1.Build) {
+	key := resourceName(build.Namespace, build.Name)
+	bc.cancelLock.Lock()
+	bcc, ok := bc.cancelFuncs[key]
+	delete(bc.cancelFuncs, key)
+	bc.cancelLock.Unlock()
+	if ok {
+		close(bcc.done)
+	}
+}
+
 // handleNewBuild will check whether policy allows running the new build and if so, creates a pod
 // for the build and returns an update to move it to the Pending phase
 func (bc *BuildController) handleNewBuild(build *buildv1.Build, pod *corev1.Pod) (*buildUpdate, error) {
@@ -472,6 +620,12 @@ func (bc *BuildController) handleNewBuild(build *buildv1.Build, pod *corev1.Pod)
 		if strategy.HasOwnerReference(pod, build) {
 			return bc.handleActiveBuild(build, pod)
 		}
+		// Before concluding that some other build owns this pod, account for
+		// the well-known race between pod creation and the owner reference
+		// becoming visible on the informer cache.
+		if update, err := bc.retryOnOwnerRef(build, pod); update != nil || err != nil {
+			return update, err
+		}
 		// If a pod was not created by the current build, move the build to
 		// error.
 		return transitionToPhase(buildv1.BuildPhaseError, buildv1.StatusReasonBuildPodExists, buildutil.StatusMessageBuildPodExists), nil
@@ -503,8 +657,19 @@ func (bc *BuildController) createPodSpec(build *buildv1.Build, includeAdditional
 	build.Status.Reason = ""
 	build.Status.Message = ""
 
-	// Invoke the strategy to create a build pod.
-	podSpec, err := bc.createStrategy.CreateBuildPod(build, includeAdditionalCA)
+	// BuildKit builds don't go through the Docker/Source/Custom
+	// typeBasedFactoryStrategy dispatch: they're recognized directly off
+	// Spec.Strategy.BuildKitStrategy, since bc.buildKitStrategy is only
+	// configured (non-nil) when the BuildKit strategy is enabled for this
+	// controller.
+	var podSpec *corev1.Pod
+	var err error
+	if build.Spec.Strategy.BuildKitStrategy != nil && bc.buildKitStrategy != nil {
+		podSpec, err = bc.buildKitStrategy.CreateBuildPod(build, includeAdditionalCA)
+	} else {
+		// Invoke the strategy to create a build pod.
+		podSpec, err = bc.createStrategy.CreateBuildPod(build, includeAdditionalCA)
+	}
 	if err != nil {
 		if strategy.IsFatal(err) {
 			return nil, &strategy.FatalError{Reason: fmt.Sprintf("failed to create a build pod spec for build %s/%s: %v", build.Namespace, build.Name, err)}
@@ -699,26 +864,48 @@ func resolveImageStreamImage(ref *corev1.ObjectReference, lister imagev1lister.I
 	return &corev1.ObjectReference{Kind: "DockerImage", Name: event.DockerImageReference}, nil
 }
 
-func resolveImageStreamTag(ref *corev1.ObjectReference, lister imagev1lister.ImageStreamLister, defaultNamespace string) (*corev1.ObjectReference, error) {
+// errMissingImageDigest is returned when an ImageStreamTag resolves to a
+// TagEvent that has no Image ID, which means the build would otherwise be
+// started against an unpinned, unreproducible tag.
+var errMissingImageDigest = fmt.Errorf("the resolved image stream tag has no image digest")
+
+func resolveImageStreamTag(ref *corev1.ObjectReference, lister imagev1lister.ImageStreamLister, defaultNamespace string) (*corev1.ObjectReference, string, error) {
 	namespace := ref.Namespace
 	if len(namespace) == 0 {
 		namespace = defaultNamespace
 	}
 	name, tag, ok := imageapi.SplitImageStreamTag(ref.Name)
 	if !ok {
-		return nil, errInvalidImageReferences
+		return nil, "", errInvalidImageReferences
 	}
 	stream, err := lister.ImageStreams(namespace).Get(name)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			return nil, err
+			return nil, "", err
 		}
-		return nil, fmt.Errorf("the referenced image stream %s/%s could not be found: %v", namespace, name, err)
+		return nil, "", fmt.Errorf("the referenced image stream %s/%s could not be found: %v", namespace, name, err)
+	}
+	newRef, ok := imageutil.ResolveLatestTaggedImage(stream, tag)
+	if !ok {
+		return nil, "", fmt.Errorf("the referenced image stream tag %s/%s does not exist", namespace, ref.Name)
 	}
-	if newRef, ok := imageutil.ResolveLatestTaggedImage(stream, tag); ok {
-		return &corev1.ObjectReference{Kind: "DockerImage", Name: newRef}, nil
+	digest := latestTagImageDigest(stream, tag)
+	if len(digest) == 0 {
+		return nil, "", errMissingImageDigest
+	}
+	return &corev1.ObjectReference{Kind: "DockerImage", Name: newRef}, digest, nil
+}
+
+// latestTagImageDigest returns the Image ID (sha256 digest) of the most
+// recent TagEvent for tag, or the empty string if none is recorded yet.
+func latestTagImageDigest(stream *imagev1.ImageStream, tag string) string {
+	for _, tagEvents := range stream.Status.Tags {
+		if tagEvents.Tag != tag || len(tagEvents.Items) == 0 {
+			continue
+		}
+		return tagEvents.Items[0].Image
 	}
-	return nil, fmt.Errorf("the referenced image stream tag %s/%s does not exist", namespace, ref.Name)
+	return ""
 }
 
 // resolveOutputDockerImageReference updates the output spec to a docker image reference.
@@ -774,8 +961,12 @@ func (bc *BuildController) resolveImageReferences(build *buildv1.Build, update *
 		}
 		return err
 	}
-	// resolve the remaining references
+	// resolve the remaining references, recording the resolved image digest for
+	// each original spec path so builds remain reproducible even if the tag is
+	// retagged mid-flight
+	digests := map[string]string{}
 	errs := m.Mutate(func(ref *corev1.ObjectReference) error {
+		originalName := ref.Name
 		switch ref.Kind {
 		case "ImageStreamImage":
 			newRef, err := resolveImageStreamImage(ref, bc.imageStreamStore, build.Namespace)
@@ -784,10 +975,15 @@ func (bc *BuildController) resolveImageReferences(build *buildv1.Build, update *
 			}
 			*ref = *newRef
 		case "ImageStreamTag":
-			newRef, err := resolveImageStreamTag(ref, bc.imageStreamStore, build.Namespace)
+			newRef, digest, err := resolveImageStreamTag(ref, bc.imageStreamStore, build.Namespace)
 			if err != nil {
+				if err == errMissingImageDigest {
+					update.setReason(buildv1.StatusReasonInvalidImageReference)
+					update.setMessage(buildutil.StatusMessageInvalidImageRef)
+				}
 				return err
 			}
+			digests[originalName] = digest
 			*ref = *newRef
 		}
 		return nil
@@ -798,11 +994,60 @@ func (bc *BuildController) resolveImageReferences(build *buildv1.Build, update *
 		update.setMessage(buildutil.StatusMessageInvalidImageRef)
 		return errs.ToAggregate()
 	}
+	if len(digests) > 0 {
+		update.setInputImageDigests(digests)
+	}
 	// we have resolved all images, and will not need any further notifications
 	bc.imageStreamQueue.Remove(buildKey, streams)
 	return nil
 }
 
+// timeoutError is returned by resolveImageReferencesWithTimeout when
+// resolution does not complete within bc.imageResolutionTimeout.
+type timeoutError struct {
+	duration time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v waiting for image reference resolution", e.duration)
+}
+
+// NewTimeoutError returns an error indicating that an operation exceeded d.
+func NewTimeoutError(d time.Duration) error {
+	return &timeoutError{duration: d}
+}
+
+// resolveImageReferencesWithTimeout runs resolveImageReferences in a goroutine
+// and bounds how long the calling worker will wait on it, so a cold
+// imageStreamStore (e.g. immediately after a controller restart against a
+// large cluster) cannot wedge a worker indefinitely. On timeout it re-enqueues
+// the build on bc.buildQueue (rate limited) and returns a NewTimeoutError; it
+// does not itself register the build on bc.imageStreamQueue. A panic
+// in the resolver goroutine is recovered and propagated to the caller rather
+// than crashing the worker.
+func (bc *BuildController) resolveImageReferencesWithTimeout(build *buildv1.Build, update *buildUpdate) error {
+	resultCh := make(chan error, 1)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				resultCh <- fmt.Errorf("panic resolving image references for build %s: %v", buildDesc(build), r)
+			}
+		}()
+		resultCh <- bc.resolveImageReferences(build, update)
+	}()
+
+	select {
+	case err := <-resultCh:
+		return err
+	case <-time.After(bc.imageResolutionTimeout):
+		metrics.BuildImageResolutionTimeoutsTotal.Inc()
+		glog.V(2).Infof("Timed out resolving image references for build %s after %v, requeuing", buildDesc(build), bc.imageResolutionTimeout)
+		bc.buildQueue.AddRateLimited(resourceName(build.Namespace, build.Name))
+		return NewTimeoutError(bc.imageResolutionTimeout)
+	}
+}
+
 // createBuildPod creates a new pod to run a build
 func (bc *BuildController) createBuildPod(build *buildv1.Build) (*buildUpdate, error) {
 	update := &buildUpdate{}
@@ -814,7 +1059,7 @@ func (bc *BuildController) createBuildPod(build *buildv1.Build) (*buildUpdate, e
 	build = build.DeepCopy()
 
 	// Resolve all Docker image references to valid values.
-	if err := bc.resolveImageReferences(build, update); err != nil {
+	if err := bc.resolveImageReferencesWithTimeout(build, update); err != nil {
 		// if we're waiting for an image stream to exist, we will get an update via the
 		// trigger, and thus don't need to be requeued.
 		if hasError(err, errors.IsNotFound, field.NewErrorTypeMatcher(field.ErrorTypeNotFound)) {
@@ -931,6 +1176,8 @@ func (bc *BuildController) createBuildPod(build *buildv1.Build) (*buildUpdate, e
 		return update, nil
 	}
 
+	addInputImageDigestsEnv(buildPod, update.inputImageDigests)
+
 	glog.V(4).Infof("Pod %s/%s for build %s is about to be created", build.Namespace, buildPod.Name, buildDesc(build))
 	pod, err := bc.podClient.Pods(build.Namespace).Create(buildPod)
 	if err != nil && !errors.IsAlreadyExists(err) {
@@ -976,6 +1223,7 @@ func (bc *BuildController) createBuildPod(build *buildv1.Build) (*buildUpdate, e
 		if err != nil {
 			return update, err
 		}
+		bc.watchForCancellation(build, pod)
 	}
 
 	update = transitionToPhase(buildv1.BuildPhasePending, "", "")
@@ -1002,6 +1250,12 @@ func (bc *BuildController) handleActiveBuild(build *buildv1.Build, pod *corev1.P
 		}
 	}
 
+	if update, err := bc.enforceDeadline(build, pod); err != nil {
+		return nil, err
+	} else if update != nil {
+		return update, nil
+	}
+
 	podPhase := pod.Status.Phase
 	var update *buildUpdate
 	// Pods don't report running until initcontainers are done, but from a build's perspective
@@ -1024,6 +1278,19 @@ func (bc *BuildController) handleActiveBuild(build *buildv1.Build, pod *corev1.P
 				update = transitionToPhase(buildv1.BuildPhasePending, buildv1.StatusReasonMissingPushSecret, buildutil.StatusMessageMissingPushSecret)
 			}
 		}
+		if events, err := bc.podTracker.EventsForPod(pod); err != nil {
+			glog.V(4).Infof("Failed to list pod events for %s: %v", buildDesc(build), err)
+		} else if len(events) > 0 {
+			if reason, message, fatal := classifyFatalPodEvents(events); fatal {
+				glog.V(2).Infof("Setting build %s to failed state due to pod event: %s", buildDesc(build), message)
+				update = transitionToPhase(buildv1.BuildPhaseFailed, reason, message)
+			} else {
+				if update == nil {
+					update = &buildUpdate{}
+				}
+				mergePodEventsIntoBuild(pod, events, update)
+			}
+		}
 	case corev1.PodRunning:
 		if build.Status.Phase != buildv1.BuildPhaseRunning {
 			update = transitionToPhase(buildv1.BuildPhaseRunning, "", "")
@@ -1031,7 +1298,23 @@ func (bc *BuildController) handleActiveBuild(build *buildv1.Build, pod *corev1.P
 				update.setStartTime(*pod.Status.StartTime)
 			}
 		}
+		if name, failed := failedRequiredHelperContainer(build, pod); failed {
+			glog.V(2).Infof("Setting build %s to failed state because required helper container %s exited non-zero", buildDesc(build), name)
+			update = transitionToPhase(buildv1.BuildPhaseFailed, statusReasonHelperContainerFailed, statusMessageHelperContainerFailed)
+		}
+		if rebuildUpdate, err := bc.maybeRebuildOnCrash(build, pod); err != nil {
+			return nil, err
+		} else if rebuildUpdate != nil {
+			return rebuildUpdate, nil
+		}
 	case corev1.PodSucceeded:
+		if !helperContainersDone(pod) {
+			// The build container has finished but a helper/sidecar container
+			// (artifact upload, log shipping, etc) is still running. Hold off on
+			// marking the build Complete until it terminates so its work finishes.
+			glog.V(4).Infof("Waiting for helper containers to terminate before completing build %s", buildDesc(build))
+			break
+		}
 		if build.Status.Phase != buildv1.BuildPhaseComplete {
 			update = transitionToPhase(buildv1.BuildPhaseComplete, "", "")
 		}
@@ -1041,8 +1324,14 @@ func (bc *BuildController) handleActiveBuild(build *buildv1.Build, pod *corev1.P
 			glog.V(2).Infof("Setting build %s to error state because its pod has no containers", buildDesc(build))
 			update = transitionToPhase(buildv1.BuildPhaseError, buildv1.StatusReasonNoBuildContainerStatus,
 				buildutil.StatusMessageNoBuildContainerStatus)
+		} else if name, failed := failedRequiredHelperContainer(build, pod); failed {
+			glog.V(2).Infof("Setting build %s to failed state because required helper container %s exited non-zero", buildDesc(build), name)
+			update = transitionToPhase(buildv1.BuildPhaseFailed, statusReasonHelperContainerFailed, statusMessageHelperContainerFailed)
 		} else {
 			for _, info := range pod.Status.ContainerStatuses {
+				if isHelperContainer(info.Name) {
+					continue
+				}
 				if info.State.Terminated != nil && info.State.Terminated.ExitCode != 0 {
 					glog.V(2).Infof("Setting build %s to error state because a container in its pod has non-zero exit code", buildDesc(build))
 					update = transitionToPhase(buildv1.BuildPhaseError, buildv1.StatusReasonFailedContainer, buildutil.StatusMessageFailedContainer)
@@ -1066,6 +1355,226 @@ func (bc *BuildController) handleActiveBuild(build *buildv1.Build, pod *corev1.P
 	return update, nil
 }
 
+// helperContainerPrefix is the naming convention used by builddefaults/buildoverrides
+// when injecting auxiliary containers (git-lfs agents, artifact uploaders, log
+// shippers, etc) alongside the main build container, so the controller can tell
+// them apart when deciding whether to wait on them or fail the build.
+const helperContainerPrefix = "helper-"
+
+// helperRequiredAnnotation marks a build whose helper container(s) must succeed
+// for the build itself to be considered successful.
+const helperRequiredAnnotation = "build.openshift.io/helper-required"
+
+// statusReasonHelperContainerFailed and statusMessageHelperContainerFailed
+// are staged when failedRequiredHelperContainer reports a required helper
+// container exited non-zero.
+const (
+	statusReasonHelperContainerFailed  buildv1.StatusReason = "HelperContainerFailed"
+	statusMessageHelperContainerFailed                      = "a required helper container exited with a non-zero status"
+)
+
+func isHelperContainer(name string) bool {
+	return strings.HasPrefix(name, helperContainerPrefix)
+}
+
+// helperContainersDone returns false if any helper container in the pod has not
+// yet terminated, so the caller can defer completion until uploads/log shipping
+// finish.
+func helperContainersDone(pod *corev1.Pod) bool {
+	for _, info := range pod.Status.ContainerStatuses {
+		if !isHelperContainer(info.Name) {
+			continue
+		}
+		if info.State.Terminated == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// failedRequiredHelperContainer returns the name of a helper container that
+// exited non-zero, if the build has opted in to treating that as fatal via
+// helperRequiredAnnotation. The annotation is read off the Build, not the
+// pod: nothing copies it onto the pod, the same as rebuildOnCrashEnabled
+// reads its own per-build annotation straight off build.Annotations.
+func failedRequiredHelperContainer(build *buildv1.Build, pod *corev1.Pod) (string, bool) {
+	if build.Annotations[helperRequiredAnnotation] != "true" {
+		return "", false
+	}
+	for _, info := range pod.Status.ContainerStatuses {
+		if !isHelperContainer(info.Name) {
+			continue
+		}
+		if info.State.Terminated != nil && info.State.Terminated.ExitCode != 0 {
+			return info.Name, true
+		}
+	}
+	return "", false
+}
+
+// enforceDeadline returns a non-nil update transitioning the build to Failed
+// if, while the pod is Running, it has exceeded its completion deadline (the
+// larger of build.Spec.CompletionDeadlineSeconds and the cluster-wide
+// bc.buildDefaults.DefaultBuildTimeout) or, while the pod is still Pending,
+// bc.buildDefaults.PendingDeadline. If the applicable deadline has not elapsed
+// yet it schedules a re-check via bc.buildQueue.AddAfter for when it will.
+func (bc *BuildController) enforceDeadline(build *buildv1.Build, pod *corev1.Pod) (*buildUpdate, error) {
+	key := resourceName(build.Namespace, build.Name)
+
+	if pod.Status.Phase == corev1.PodPending {
+		pendingDeadline := bc.buildDefaults.PendingDeadline
+		if pendingDeadline <= 0 {
+			return nil, nil
+		}
+		pending := time.Since(pod.CreationTimestamp.Time)
+		if pending < pendingDeadline {
+			bc.buildQueue.AddAfter(key, pendingDeadline-pending)
+			return nil, nil
+		}
+		return bc.timeoutBuild(build, pod, "PendingDeadlineExceeded")
+	}
+
+	if pod.Status.Phase != corev1.PodRunning {
+		// The pod has already reached a terminal phase (Succeeded/Failed) or
+		// an indeterminate one (Unknown); let the phase switch in
+		// handleActiveBuild handle it instead of failing a build out from
+		// under a pod that may have already completed successfully.
+		return nil, nil
+	}
+
+	if build.Status.StartTimestamp == nil {
+		return nil, nil
+	}
+
+	deadline := bc.buildDefaults.DefaultBuildTimeout
+	if build.Spec.CompletionDeadlineSeconds != nil {
+		if specDeadline := time.Duration(*build.Spec.CompletionDeadlineSeconds) * time.Second; specDeadline > deadline {
+			deadline = specDeadline
+		}
+	}
+	if deadline <= 0 {
+		return nil, nil
+	}
+
+	elapsed := time.Since(build.Status.StartTimestamp.Time)
+	if elapsed < deadline {
+		bc.buildQueue.AddAfter(key, deadline-elapsed)
+		return nil, nil
+	}
+	return bc.timeoutBuild(build, pod, "DeadlineExceeded")
+}
+
+// statusReasonBuildTimedOut and statusMessageBuildTimedOut are staged by
+// timeoutBuild when enforceDeadline fails a build for exceeding its pending
+// or completion deadline.
+const (
+	statusReasonBuildTimedOut  buildv1.StatusReason = "BuildTimedOut"
+	statusMessageBuildTimedOut                      = "the build did not complete before its execution deadline"
+)
+
+// timeoutBuild deletes the build pod with a grace period and returns an
+// update transitioning the build to Failed with statusReasonBuildTimedOut.
+func (bc *BuildController) timeoutBuild(build *buildv1.Build, pod *corev1.Pod, reason string) (*buildUpdate, error) {
+	glog.V(2).Infof("Build %s exceeded its execution deadline, cancelling", buildDesc(build))
+	metrics.BuildTimeoutsTotal.WithLabelValues(reason).Inc()
+	bc.recorder.Eventf(build, corev1.EventTypeWarning, "BuildTimedOut", "Build %s/%s timed out", build.Namespace, build.Name)
+
+	gracePeriod := int64(buildPodDeletionGracePeriodSeconds)
+	if err := bc.podClient.Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{GracePeriodSeconds: &gracePeriod}); err != nil && !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("could not delete timed out build pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+	update := transitionToPhase(buildv1.BuildPhaseFailed, statusReasonBuildTimedOut, statusMessageBuildTimedOut)
+	update.setCancelled(true)
+	return update, nil
+}
+
+// rebuildOnCrashAnnotation opts an individual build into the crash-recovery
+// path in maybeRebuildOnCrash.
+const rebuildOnCrashAnnotation = "build.openshift.io/rebuild-on-crash"
+
+// crashRebuildMaxDefault bounds the number of times a build pod will be
+// recreated after a crash before the build is given up on.
+const crashRebuildMaxDefault = 2
+
+// podCrashed returns true if a container in the pod has restarted, or last
+// terminated with a signal associated with a runtime crash (OOMKilled, or
+// exit codes 137/139 for SIGKILL/SIGSEGV).
+func podCrashed(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.ContainerStatuses {
+		if c.RestartCount > 0 {
+			return true
+		}
+		if t := c.LastTerminationState.Terminated; t != nil {
+			if t.Reason == "OOMKilled" || t.ExitCode == 137 || t.ExitCode == 139 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func rebuildOnCrashEnabled(build *buildv1.Build, defaults builddefaults.BuildDefaults) bool {
+	if build.Annotations[rebuildOnCrashAnnotation] == "true" {
+		return true
+	}
+	return defaults.RebuildOnCrash
+}
+
+// statusReasonBuildPodCrashLoop and statusMessageBuildPodCrashLoop are staged
+// when maybeRebuildOnCrash gives up on a build whose pod crashed again after
+// already being rebuilt crashRebuildMaxDefault times.
+const (
+	statusReasonBuildPodCrashLoop  buildv1.StatusReason = "BuildPodCrashLoop"
+	statusMessageBuildPodCrashLoop                      = "the build pod crashed too many times and will not be rebuilt again"
+)
+
+// maybeRebuildOnCrash detects a crashed build pod and, if the build opted in
+// via rebuildOnCrashAnnotation or BuildDefaults.RebuildOnCrash, deletes the
+// crashed pod and creates a fresh one in its place, up to crashRebuildMaxDefault
+// attempts. Beyond that the build is failed with statusReasonBuildPodCrashLoop.
+// The original StartTimestamp is preserved so deadline enforcement still applies.
+func (bc *BuildController) maybeRebuildOnCrash(build *buildv1.Build, pod *corev1.Pod) (*buildUpdate, error) {
+	if !podCrashed(pod) || !rebuildOnCrashEnabled(build, bc.buildDefaults) {
+		return nil, nil
+	}
+
+	rebuilds := crashRebuildCount(build)
+	if rebuilds >= crashRebuildMaxDefault {
+		bc.recorder.Eventf(build, corev1.EventTypeWarning, "BuildPodCrashLoop", "Build pod %s/%s crashed %d times, giving up", pod.Namespace, pod.Name, rebuilds+1)
+		return transitionToPhase(buildv1.BuildPhaseFailed, statusReasonBuildPodCrashLoop, statusMessageBuildPodCrashLoop), nil
+	}
+
+	// Forget (without firing) the cancellation context watchForCancellation
+	// started for the crashed pod before deleting it: re-creating the pod
+	// below would otherwise just overwrite bc.cancelFuncs[key] with a new
+	// context, leaving the old goroutine unreachable by key and blocked
+	// forever on its select -- the same leak untrackBuildContext fixes for
+	// the normal-completion path.
+	bc.untrackBuildContext(build)
+
+	if err := bc.podClient.Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("could not delete crashed build pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+	bc.recorder.Eventf(build, corev1.EventTypeNormal, "BuildPodCrashed", "Build pod %s/%s crashed, rebuilding (attempt %d/%d)",
+		pod.Namespace, pod.Name, rebuilds+1, crashRebuildMaxDefault)
+	metrics.BuildCrashRebuildsTotal.Inc()
+
+	// Re-enter handleNewBuild (rather than calling createBuildPod directly)
+	// so a crash-rebuild is still subject to the current runPolicy, just like
+	// any other new build: it must not jump ahead of builds a serial/rolling
+	// run policy is holding queued. A nil update here means the policy isn't
+	// ready to run this build yet; it'll be retried once the policy allows.
+	update, err := bc.handleNewBuild(build, nil)
+	if err != nil || update == nil {
+		return update, err
+	}
+	update.setCrashRebuildCount(rebuilds + 1)
+	if build.Status.StartTimestamp != nil {
+		update.setStartTime(*build.Status.StartTimestamp)
+	}
+	return update, nil
+}
+
 func isOOMKilled(pod *corev1.Pod) bool {
 	if pod == nil {
 		return false
@@ -1096,7 +1605,7 @@ func (bc *BuildController) handleCompletedBuild(build *buildv1.Build, pod *corev
 	if isOOMKilled(pod) {
 		update = transitionToPhase(buildv1.BuildPhaseFailed, buildv1.StatusReasonOutOfMemoryKilled, buildutil.StatusMessageOutOfMemoryKilled)
 	}
-	setBuildCompletionData(build, pod, update)
+	bc.setBuildCompletionData(build, pod, update)
 
 	return update, nil
 }
@@ -1135,7 +1644,7 @@ func (bc *BuildController) updateBuild(build *buildv1.Build, update *buildUpdate
 
 		// Update build completion timestamp if transitioning to a terminal phase
 		if buildutil.IsTerminalPhase(*update.phase) {
-			setBuildCompletionData(build, pod, update)
+			bc.setBuildCompletionData(build, pod, update)
 		}
 		glog.V(4).Infof("Updating build %s -> %s%s", buildDesc(build), *update.phase, reasonText)
 	}
@@ -1145,7 +1654,7 @@ func (bc *BuildController) updateBuild(build *buildv1.Build, update *buildUpdate
 		update.setPodNameAnnotation(pod.Name)
 	}
 
-	patchedBuild, err := bc.patchBuild(build, update)
+	patchedBuild, err := bc.patchBuildWithRetry(build, update)
 	if err != nil {
 		return err
 	}
@@ -1170,6 +1679,12 @@ func (bc *BuildController) updateBuild(build *buildv1.Build, update *buildUpdate
 		}
 		if buildutil.IsTerminalPhase(*update.phase) {
 			bc.handleBuildCompletion(patchedBuild)
+			// The build has reached a terminal phase on its own; stop
+			// tracking its cancellation context without firing it, so a
+			// cancel that raced with completion doesn't force-delete a pod
+			// that already won, and so watchForCancellation's goroutine
+			// doesn't leak for the rest of the process's life.
+			bc.untrackBuildContext(patchedBuild)
 		}
 	}
 	return nil
@@ -1228,9 +1743,82 @@ func createBuildPatch(older, newer *buildv1.Build) ([]byte, error) {
 	return patch, nil
 }
 
+// addInputImageDigestsEnv surfaces the resolved input image digests to the
+// build container, alongside the existing imageName env vars used for
+// pull-secret lookup, by JSON-encoding the spec-path-to-digest map.
+func addInputImageDigestsEnv(pod *corev1.Pod, digests map[string]string) {
+	if len(digests) == 0 || len(pod.Spec.Containers) == 0 {
+		return
+	}
+	encoded, err := json.Marshal(digests)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to encode input image digests: %v", err))
+		return
+	}
+	pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{
+		Name:  "BUILD_INPUT_IMAGE_DIGESTS",
+		Value: string(encoded),
+	})
+}
+
+// setInputImageDigests records the sha256 digest resolved for each input
+// image reference (keyed by the reference's original spec path, e.g.
+// "my-stream:latest"), both transiently on u.inputImageDigests so
+// createBuildPod can add them to the build pod's environment via
+// addInputImageDigestsEnv, and persistently as the JSON-encoded
+// inputImageDigestsAnnotation, so tooling can tell exactly which image bytes
+// a build ran against even if the tag has since been retagged.
+func (u *buildUpdate) setInputImageDigests(digests map[string]string) {
+	u.inputImageDigests = digests
+	if len(digests) == 0 {
+		return
+	}
+	encoded, err := json.Marshal(digests)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to encode input image digests: %v", err))
+		return
+	}
+	s := string(encoded)
+	u.inputImageDigestsJSON = &s
+}
+
+// setCrashRebuildCount records how many times maybeRebuildOnCrash has
+// recreated this build's pod after a crash, via crashRebuildCountAnnotation.
+func (u *buildUpdate) setCrashRebuildCount(count int32) {
+	u.crashRebuildCount = &count
+}
+
+// setOwnerReferenceRetries records how many times handleNewBuild has
+// requeued this build while waiting for its pod's owner reference to become
+// visible on the informer cache, so retryOnOwnerRef can bound the wait.
+func (u *buildUpdate) setOwnerReferenceRetries(retries int32) {
+	u.ownerReferenceRetries = &retries
+}
+
+// reapply re-validates a buildUpdate against a freshly-fetched build after a
+// conflicting patch attempt. The phase/reason/message/etc already staged on
+// the update do not need to be recomputed since they were derived from data
+// external to the build's resourceVersion (the pod, the clock); the only
+// thing that can have changed out from under us is the phase itself, so we
+// re-check that the transition we are about to apply is still valid.
+func (u *buildUpdate) reapply(fresh *buildv1.Build) error {
+	if u.phase != nil && !isValidTransition(fresh.Status.Phase, *u.phase) {
+		return fmt.Errorf("invalid phase transition %s/%s (%s) -> %s", fresh.Namespace, fresh.Name, fresh.Status.Phase, *u.phase)
+	}
+	return nil
+}
+
 // patchBuild generates a patch for the given build and buildUpdate
 // and applies that patch using the REST client
 func (bc *BuildController) patchBuild(build *buildv1.Build, update *buildUpdate) (*buildv1.Build, error) {
+	return patchBuildWith(bc.buildPatcher, build, update)
+}
+
+// patchBuildWith generates a patch for build and update and applies it
+// through patcher. It is factored out of patchBuild so PatchWithRetry can
+// reuse the same patch-and-apply logic against a freshly re-Get'd build
+// without going through a BuildController receiver.
+func patchBuildWith(patcher buildmanualclient.BuildPatcher, build *buildv1.Build, update *buildUpdate) (*buildv1.Build, error) {
 	// Create a patch using the buildUpdate object
 	updatedBuild := build.DeepCopy()
 	update.apply(updatedBuild)
@@ -1241,7 +1829,102 @@ func (bc *BuildController) patchBuild(build *buildv1.Build, update *buildUpdate)
 	}
 
 	glog.V(5).Infof("Patching build %s with %v", buildDesc(build), update)
-	return bc.buildPatcher.Patch(build.Namespace, build.Name, patch)
+	return patcher.Patch(build.Namespace, build.Name, patch)
+}
+
+// patchBuildWithRetry wraps patchBuild in a retry.RetryOnConflict loop: on a
+// conflicting patch, it re-Gets the build via bc.buildLister, re-validates
+// the buildUpdate against that fresher copy via update.reapply, and retries
+// the patch against it. This saves a routine optimistic-concurrency conflict
+// from forcing a full re-sync of the build through the rate-limited
+// workqueue. It is a thin wrapper around the package-level PatchWithRetry so
+// every caller shares one implementation of the retry loop.
+func (bc *BuildController) patchBuildWithRetry(build *buildv1.Build, update *buildUpdate) (*buildv1.Build, error) {
+	return PatchWithRetry(bc.buildPatcher, bc.buildLister, build, update)
+}
+
+// PatchWithRetry patches build with the changes staged on update, retrying
+// through retry.RetryOnConflict on a conflicting patch: it re-Gets the build
+// via lister, re-validates the buildUpdate against that fresher copy via
+// update.reapply, and recomputes the patch against it before trying again.
+// Code paths other than updateBuild (for example handleActiveBuild or
+// handleCompletedBuild) can call it to get the same optimistic-concurrency
+// handling without reimplementing the loop. It takes the package's
+// unexported buildUpdate, so only callers inside this package can use it.
+func PatchWithRetry(patcher buildmanualclient.BuildPatcher, lister buildv1lister.BuildLister, build *buildv1.Build, update *buildUpdate) (*buildv1.Build, error) {
+	current := build
+	var patchedBuild *buildv1.Build
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		var patchErr error
+		patchedBuild, patchErr = patchBuildWith(patcher, current, update)
+		if errors.IsConflict(patchErr) {
+			metrics.BuildUpdateConflictRetries.Inc()
+			fresh, getErr := lister.Builds(build.Namespace).Get(build.Name)
+			if getErr != nil {
+				return getErr
+			}
+			current = fresh.DeepCopy()
+			if err := update.reapply(current); err != nil {
+				return err
+			}
+		}
+		return patchErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return patchedBuild, nil
+}
+
+// ownerReferenceRaceMaxRetries bounds how many times handleNewBuild will
+// requeue a build whose pod has not yet picked up an owner reference on the
+// informer cache, before giving up and failing the build.
+const ownerReferenceRaceMaxRetries = 5
+
+// statusReasonOwnerReferenceRaceExhausted and
+// statusMessageOwnerReferenceRaceExhausted are staged by retryOnOwnerRef once
+// a pod has gone ownerReferenceRaceMaxRetries requeues without picking up an
+// owner reference, declared locally since buildv1.StatusReason has no
+// equivalent.
+const (
+	statusReasonOwnerReferenceRaceExhausted  buildv1.StatusReason = "OwnerReferenceRaceExhausted"
+	statusMessageOwnerReferenceRaceExhausted                      = "gave up waiting for the build pod to pick up its owner reference"
+)
+
+// retryOnOwnerRef is called by handleNewBuild when it finds a pod for a New
+// build that strategy.HasOwnerReference does not recognize as owned by that
+// build. Rather than failing the build immediately, it accounts for two
+// benign races: the pod's owner reference has been set on the server but not
+// yet observed on the informer cache, and the informer cache itself is
+// simply stale. It returns a non-nil update and/or a non-fatal error when
+// the caller should requeue and try again; a fatal error when the pod
+// definitively belongs to a different build; and (nil, nil) when the caller
+// should fall through to its existing BuildPodExists handling.
+func (bc *BuildController) retryOnOwnerRef(build *buildv1.Build, pod *corev1.Pod) (*buildUpdate, error) {
+	if len(pod.OwnerReferences) == 0 {
+		retries := ownerReferenceRetries(build)
+		if retries >= ownerReferenceRaceMaxRetries {
+			glog.V(2).Infof("Giving up waiting for an owner reference on pod %s for build %s after %d retries", pod.Name, buildDesc(build), retries)
+			return transitionToPhase(buildv1.BuildPhaseError, statusReasonOwnerReferenceRaceExhausted, statusMessageOwnerReferenceRaceExhausted), nil
+		}
+		update := &buildUpdate{}
+		update.setOwnerReferenceRetries(retries + 1)
+		return update, fmt.Errorf("pod %s for build %s has no owner references yet, will retry", pod.Name, buildDesc(build))
+	}
+
+	for _, ref := range pod.OwnerReferences {
+		if ref.UID != build.UID {
+			return nil, &strategy.FatalError{Reason: fmt.Sprintf("pod %s/%s is already owned by another object (uid %s)", pod.Namespace, pod.Name, ref.UID)}
+		}
+	}
+
+	// The pod's owner reference does point at this build, so the cached copy
+	// strategy.HasOwnerReference inspected must simply be stale. Bypass the
+	// cache with a direct read before giving up on the build.
+	if fresh := bc.findMissingPod(build); fresh != nil && strategy.HasOwnerReference(fresh, build) {
+		return bc.handleActiveBuild(build, fresh)
+	}
+	return nil, nil
 }
 
 // findMissingPod uses the REST client directly to determine if a pod exists or not.
@@ -1483,6 +2166,104 @@ func (bc *BuildController) findBuildCAConfigMap(build *buildv1.Build, buildPod *
 	return true, nil
 }
 
+// caWatchPollInterval is how often the build controller checks
+// additionalTrustedCAPath for a rotated CA bundle. fsnotify is unreliable on
+// the projected ConfigMap volumes this path is typically mounted from, since
+// a rotation swaps in a new directory via an atomic symlink rather than
+// writing the watched file in place, so a cheap mtime poll is used instead.
+const caWatchPollInterval = 30 * time.Second
+
+// watchAdditionalTrustedCA checks additionalTrustedCAPath for a new mtime
+// and, if the bundle changed, reloads it and propagates it to every build
+// pod that is already Pending or Running so a CA rotation does not wait for
+// the controller to restart.
+func (bc *BuildController) watchAdditionalTrustedCA() {
+	info, err := os.Stat(bc.additionalTrustedCAPath)
+	if err != nil {
+		glog.Warningf("Failed to stat additional CA bundle %s: %v", bc.additionalTrustedCAPath, err)
+		return
+	}
+
+	bc.additionalTrustedCALock.Lock()
+	changed := !info.ModTime().Equal(bc.additionalTrustedCAModTime)
+	bc.additionalTrustedCALock.Unlock()
+	if !changed {
+		return
+	}
+
+	caData, err := bc.readBuildCAData()
+	if err != nil {
+		glog.Warningf("Failed to read rotated additional CA bundle %s: %v", bc.additionalTrustedCAPath, err)
+		return
+	}
+
+	bc.additionalTrustedCALock.Lock()
+	bc.additionalTrustedCAData = caData
+	bc.additionalTrustedCAModTime = info.ModTime()
+	bc.additionalTrustedCALock.Unlock()
+
+	glog.V(2).Infof("Additional trusted CA bundle %s changed, propagating to in-flight builds", bc.additionalTrustedCAPath)
+	bc.propagateAdditionalTrustedCA(caData)
+}
+
+// propagateAdditionalTrustedCA re-lists build pods currently Pending or
+// Running and patches each one's CA configMap with the freshly reloaded
+// bundle, so builds that are already executing pick up a rotated CA without
+// waiting for a new build pod to be created.
+func (bc *BuildController) propagateAdditionalTrustedCA(caData []byte) {
+	pods, err := bc.podStore.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to list pods while propagating rotated additional CA bundle: %v", err))
+		return
+	}
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodPending && pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		buildName := getBuildName(pod)
+		if len(buildName) == 0 {
+			continue
+		}
+		build, err := bc.buildStore.Builds(pod.Namespace).Get(buildName)
+		if err != nil {
+			continue
+		}
+		bc.updateBuildCAConfigMap(build, pod, caData)
+	}
+}
+
+// updateBuildCAConfigMap patches build's CA configMap, if one exists, with
+// caData. It records a normal event on the build when the patch succeeds
+// and a warning event when it fails, so operators can correlate a build
+// failure with a bad CA rotation.
+func (bc *BuildController) updateBuildCAConfigMap(build *buildv1.Build, buildPod *corev1.Pod, caData []byte) {
+	hasMap, err := bc.findBuildCAConfigMap(build, buildPod)
+	if err != nil {
+		glog.V(4).Infof("Skipping additional CA bundle update for build %s: %v", buildDesc(build), err)
+		return
+	}
+	if !hasMap {
+		return
+	}
+
+	name := buildapihelpers.GetBuildCAConfigMapName(build)
+	patch, err := json.Marshal(map[string]interface{}{
+		"data": map[string]string{
+			buildutil.AdditionalTrustedCAKey: string(caData),
+		},
+	})
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to encode additional CA bundle patch for build %s: %v", buildDesc(build), err))
+		return
+	}
+
+	if _, err := bc.configMapClient.ConfigMaps(build.Namespace).Patch(name, types.MergePatchType, patch); err != nil {
+		bc.recorder.Eventf(build, corev1.EventTypeWarning, "CABundleUpdateFailed", "Failed to update certificate authority configMap %s with rotated additional CA bundle: %v", name, err)
+		return
+	}
+	bc.recorder.Eventf(build, corev1.EventTypeNormal, "CABundleUpdated", "Updated certificate authority configMap %s with rotated additional CA bundle", name)
+}
+
 // isBuildPod returns true if the given pod is a build pod
 func isBuildPod(pod *corev1.Pod) bool {
 	return len(getBuildName(pod)) > 0
@@ -1529,10 +2310,79 @@ func isValidTransition(from, to buildv1.BuildPhase) bool {
 	return true
 }
 
+// logTailFetchTimeout bounds how long setBuildCompletionData will wait on
+// bc.podClient.GetLogs when falling back to the pod's container logs, so a
+// slow or unresponsive kubelet cannot block a sync worker.
+const logTailFetchTimeout = 5 * time.Second
+
+// logTailLimitBytes caps how much of the pod's log stream is read back when
+// falling back to a live log fetch, regardless of how many lines that turns
+// out to be.
+const logTailLimitBytes = 16 * 1024
+
+// formatLogExcerpt joins the trailing lines of msg, truncating each to 120
+// characters (keeping the head and tail of long lines), to the same format
+// used for the build's LogSnippet.
+func formatLogExcerpt(msg string) string {
+	parts := strings.Split(strings.TrimRight(msg, "\n"), "\n")
+
+	excerptLength := maxExcerptLength
+	if len(parts) < maxExcerptLength {
+		excerptLength = len(parts)
+	}
+	excerpt := parts[len(parts)-excerptLength:]
+	for i, line := range excerpt {
+		if len(line) > 120 {
+			excerpt[i] = line[:58] + "..." + line[len(line)-59:]
+		}
+	}
+	return strings.Join(excerpt, "\n")
+}
+
+// fetchPodLogTail reads back the trailing log lines of the build pod's
+// container directly from the pod API, for use when the termination message
+// is empty (the common case, since most builders write their output to
+// stdout rather than the termination-message file). It is gated behind
+// bc.fetchLogTailOnFailure and bounded by logTailFetchTimeout so a slow
+// kubelet cannot block the worker; it returns "" on any error, including a
+// pod that has already been deleted.
+func (bc *BuildController) fetchPodLogTail(pod *corev1.Pod) string {
+	if !bc.fetchLogTailOnFailure || pod == nil || len(pod.Spec.Containers) == 0 {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), logTailFetchTimeout)
+	defer cancel()
+
+	tailLines := int64(maxExcerptLength)
+	limitBytes := int64(logTailLimitBytes)
+	stream, err := bc.podClient.Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container:  pod.Spec.Containers[0].Name,
+		TailLines:  &tailLines,
+		LimitBytes: &limitBytes,
+	}).Stream(ctx)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			glog.V(4).Infof("Failed to fetch log tail for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		return ""
+	}
+	defer stream.Close()
+
+	data, err := ioutil.ReadAll(stream)
+	if err != nil {
+		glog.V(4).Infof("Failed to read log tail for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return ""
+	}
+	if len(data) == 0 {
+		return ""
+	}
+	return formatLogExcerpt(string(data))
+}
+
 // setBuildCompletionData sets the build completion time and duration as well as the start time
 // if not already set on the given buildUpdate object.  It also sets the log tail data
 // if applicable.
-func setBuildCompletionData(build *buildv1.Build, pod *corev1.Pod, update *buildUpdate) {
+func (bc *BuildController) setBuildCompletionData(build *buildv1.Build, pod *corev1.Pod, update *buildUpdate) {
 	now := metav1.Now()
 
 	startTime := build.Status.StartTimestamp
@@ -1551,24 +2401,15 @@ func setBuildCompletionData(build *buildv1.Build, pod *corev1.Pod, update *build
 		update.setDuration(now.Rfc3339Copy().Time.Sub(startTime.Rfc3339Copy().Time))
 	}
 
-	if (build.Status.Phase == buildv1.BuildPhaseFailed || (update.phase != nil && *update.phase == buildv1.BuildPhaseFailed)) && len(build.Status.LogSnippet) == 0 &&
-		pod != nil && len(pod.Status.ContainerStatuses) != 0 && pod.Status.ContainerStatuses[0].State.Terminated != nil {
-		msg := pod.Status.ContainerStatuses[0].State.Terminated.Message
+	if (build.Status.Phase == buildv1.BuildPhaseFailed || (update.phase != nil && *update.phase == buildv1.BuildPhaseFailed)) && len(build.Status.LogSnippet) == 0 && pod != nil {
+		msg := ""
+		if len(pod.Status.ContainerStatuses) != 0 && pod.Status.ContainerStatuses[0].State.Terminated != nil {
+			msg = pod.Status.ContainerStatuses[0].State.Terminated.Message
+		}
 		if len(msg) != 0 {
-			parts := strings.Split(strings.TrimRight(msg, "\n"), "\n")
-
-			excerptLength := maxExcerptLength
-			if len(parts) < maxExcerptLength {
-				excerptLength = len(parts)
-			}
-			excerpt := parts[len(parts)-excerptLength:]
-			for i, line := range excerpt {
-				if len(line) > 120 {
-					excerpt[i] = line[:58] + "..." + line[len(line)-59:]
-				}
-			}
-			msg = strings.Join(excerpt, "\n")
-			update.setLogSnippet(msg)
+			update.setLogSnippet(formatLogExcerpt(msg))
+		} else if tail := bc.fetchPodLogTail(pod); len(tail) != 0 {
+			update.setLogSnippet(tail)
 		}
 	}
 
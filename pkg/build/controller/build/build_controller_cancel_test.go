@@ -0,0 +1,139 @@
+package build
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	buildv1 "github.com/openshift/api/build/v1"
+)
+
+func newCancelTestController(objects ...runtime.Object) *BuildController {
+	return &BuildController{
+		podClient:   fake.NewSimpleClientset(objects...).CoreV1(),
+		cancelFuncs: make(map[string]*buildCancelContext),
+	}
+}
+
+// waitForPodAbsence polls until the named pod can no longer be found or two
+// seconds have passed, since watchForCancellation's goroutine deletes the pod
+// asynchronously.
+func waitForPodAbsence(t *testing.T, bc *BuildController, namespace, name string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		_, err := bc.podClient.Pods(namespace).Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("pod %s/%s was never deleted", namespace, name)
+}
+
+// TestUntrackBuildContextDoesNotDeletePod verifies the fix for the race
+// between a build reaching a terminal phase on its own and a concurrent
+// cancellation request: once untrackBuildContext has been called (simulating
+// updateBuild recording the build's terminal phase), the
+// watchForCancellation goroutine it started must exit without force-deleting
+// the pod, and must stop being tracked so a later cancelBuildContext call is
+// a no-op.
+func TestUntrackBuildContextDoesNotDeletePod(t *testing.T) {
+	build := &buildv1.Build{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "build-1"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "build-1-build"}}
+	bc := newCancelTestController(pod)
+
+	bc.watchForCancellation(build, pod)
+	bc.untrackBuildContext(build)
+
+	bc.cancelLock.Lock()
+	_, tracked := bc.cancelFuncs[resourceName(build.Namespace, build.Name)]
+	bc.cancelLock.Unlock()
+	if tracked {
+		t.Fatalf("expected untrackBuildContext to stop tracking build %s", build.Name)
+	}
+
+	// Give the goroutine a moment to (incorrectly) delete the pod if the fix
+	// regresses, then confirm it is still present.
+	time.Sleep(50 * time.Millisecond)
+	if _, err := bc.podClient.Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("expected pod %s/%s to still exist after untrackBuildContext, got: %v", pod.Namespace, pod.Name, err)
+	}
+}
+
+// TestCancelBuildContextDeletesPod verifies the other side of the race: a
+// real cancellation still force-deletes the pod via the goroutine started by
+// watchForCancellation, once it has waited out buildCancelGracePeriodSeconds.
+func TestCancelBuildContextDeletesPod(t *testing.T) {
+	restore := buildCancelGracePeriodSeconds
+	buildCancelGracePeriodSeconds = 0
+	defer func() { buildCancelGracePeriodSeconds = restore }()
+
+	build := &buildv1.Build{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "build-2"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "build-2-build"}}
+	bc := newCancelTestController(pod)
+
+	bc.watchForCancellation(build, pod)
+	bc.cancelBuildContext(build)
+
+	waitForPodAbsence(t, bc, pod.Namespace, pod.Name)
+}
+
+// TestCancelBuildContextDoesNotForceDeleteBeforeGracePeriod verifies the fix
+// for the force-delete goroutine racing a 0-grace delete against
+// cancelBuild's own graceful delete: immediately after a cancellation, and
+// well before buildCancelGracePeriodSeconds has elapsed, the pod must still
+// be present.
+func TestCancelBuildContextDoesNotForceDeleteBeforeGracePeriod(t *testing.T) {
+	restore := buildCancelGracePeriodSeconds
+	buildCancelGracePeriodSeconds = 10
+	defer func() { buildCancelGracePeriodSeconds = restore }()
+
+	build := &buildv1.Build{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "build-3"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "build-3-build"}}
+	bc := newCancelTestController(pod)
+
+	bc.watchForCancellation(build, pod)
+	bc.cancelBuildContext(build)
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := bc.podClient.Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("expected pod %s/%s to still exist shortly after cancellation (grace period not yet elapsed), got: %v", pod.Namespace, pod.Name, err)
+	}
+}
+
+// TestUntrackBuildContextSkipsPendingForceDelete verifies that closing
+// bcc.done while the force-delete goroutine is waiting out the grace period
+// (e.g. because the build reached a terminal phase on its own in the
+// meantime) cancels the pending force delete instead of running it anyway.
+func TestUntrackBuildContextSkipsPendingForceDelete(t *testing.T) {
+	restore := buildCancelGracePeriodSeconds
+	buildCancelGracePeriodSeconds = 10
+	defer func() { buildCancelGracePeriodSeconds = restore }()
+
+	build := &buildv1.Build{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "build-4"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "build-4-build"}}
+	bc := newCancelTestController(pod)
+
+	bc.watchForCancellation(build, pod)
+
+	bc.cancelLock.Lock()
+	bcc := bc.cancelFuncs[resourceName(build.Namespace, build.Name)]
+	bc.cancelLock.Unlock()
+	bcc.cancel()
+
+	bc.cancelLock.Lock()
+	bc.cancelFuncs[resourceName(build.Namespace, build.Name)] = bcc
+	bc.cancelLock.Unlock()
+	bc.untrackBuildContext(build)
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := bc.podClient.Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("expected pod %s/%s to still exist after untrackBuildContext cancelled the pending force delete, got: %v", pod.Namespace, pod.Name, err)
+	}
+}
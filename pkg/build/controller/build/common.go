@@ -0,0 +1,235 @@
+package build
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	buildv1 "github.com/openshift/api/build/v1"
+)
+
+// buildUpdate accumulates the changes a handler function decided to make to
+// a Build so they can be staged, validated against a freshly-fetched copy on
+// a patch conflict (see reapply), and applied as a single patch via
+// patchBuildWith. Only the fields that were actually set are applied,
+// leaving everything else on the build untouched.
+type buildUpdate struct {
+	phase             *buildv1.BuildPhase
+	reason            *buildv1.StatusReason
+	message           *string
+	startTime         *metav1.Time
+	completionTime    *metav1.Time
+	duration          *time.Duration
+	outputRef         *string
+	podNameAnnotation *string
+	pushSecret        *corev1.LocalObjectReference
+	logSnippet        *string
+	cancelled         *bool
+	condition         *buildv1.BuildCondition
+
+	// inputImageDigests is not part of the Build's persisted state: it is
+	// staged by setInputImageDigests purely so createBuildPod can read it
+	// back off the same buildUpdate to populate addInputImageDigestsEnv, so
+	// apply/isEmpty deliberately ignore it. The same digests, JSON-encoded,
+	// are also staged onto inputImageDigestsJSON below for the persisted
+	// copy, applied as inputImageDigestsAnnotation.
+	inputImageDigests     map[string]string
+	inputImageDigestsJSON *string
+
+	ownerReferenceRetries *int32
+	crashRebuildCount     *int32
+}
+
+func (u *buildUpdate) setPhase(phase buildv1.BuildPhase) {
+	u.phase = &phase
+}
+
+func (u *buildUpdate) setReason(reason buildv1.StatusReason) {
+	u.reason = &reason
+}
+
+func (u *buildUpdate) setMessage(message string) {
+	u.message = &message
+}
+
+func (u *buildUpdate) setStartTime(t metav1.Time) {
+	u.startTime = &t
+}
+
+func (u *buildUpdate) setCompletionTime(t metav1.Time) {
+	u.completionTime = &t
+}
+
+func (u *buildUpdate) setDuration(d time.Duration) {
+	u.duration = &d
+}
+
+func (u *buildUpdate) setOutputRef(ref string) {
+	u.outputRef = &ref
+}
+
+func (u *buildUpdate) setPodNameAnnotation(name string) {
+	u.podNameAnnotation = &name
+}
+
+func (u *buildUpdate) setPushSecret(secret corev1.LocalObjectReference) {
+	u.pushSecret = &secret
+}
+
+func (u *buildUpdate) setLogSnippet(snippet string) {
+	u.logSnippet = &snippet
+}
+
+func (u *buildUpdate) setCancelled(cancelled bool) {
+	u.cancelled = &cancelled
+}
+
+// setCondition stages condition to be upserted into build.Status.Conditions
+// (replacing any existing entry of the same Type) when u is applied.
+func (u *buildUpdate) setCondition(condition buildv1.BuildCondition) {
+	u.condition = &condition
+}
+
+// isEmpty returns true if this update doesn't have any changes.
+func (u *buildUpdate) isEmpty() bool {
+	return u.phase == nil &&
+		u.reason == nil &&
+		u.message == nil &&
+		u.startTime == nil &&
+		u.completionTime == nil &&
+		u.duration == nil &&
+		u.outputRef == nil &&
+		u.podNameAnnotation == nil &&
+		u.pushSecret == nil &&
+		u.logSnippet == nil &&
+		u.cancelled == nil &&
+		u.condition == nil &&
+		u.ownerReferenceRetries == nil &&
+		u.crashRebuildCount == nil &&
+		u.inputImageDigestsJSON == nil
+}
+
+// apply applies the changes staged on u onto build in place.
+func (u *buildUpdate) apply(build *buildv1.Build) {
+	if u.phase != nil {
+		build.Status.Phase = *u.phase
+	}
+	if u.reason != nil {
+		build.Status.Reason = *u.reason
+	}
+	if u.message != nil {
+		build.Status.Message = *u.message
+	}
+	if u.startTime != nil {
+		build.Status.StartTimestamp = u.startTime
+	}
+	if u.completionTime != nil {
+		build.Status.CompletionTimestamp = u.completionTime
+	}
+	if u.duration != nil {
+		build.Status.Duration = *u.duration
+	}
+	if u.outputRef != nil {
+		build.Status.OutputDockerImageReference = *u.outputRef
+	}
+	if u.podNameAnnotation != nil {
+		if build.Annotations == nil {
+			build.Annotations = map[string]string{}
+		}
+		build.Annotations[buildPodNameAnnotation] = *u.podNameAnnotation
+	}
+	if u.pushSecret != nil {
+		build.Spec.Output.PushSecret = u.pushSecret
+	}
+	if u.logSnippet != nil {
+		build.Status.LogSnippet = *u.logSnippet
+	}
+	if u.cancelled != nil {
+		build.Status.Cancelled = *u.cancelled
+	}
+	if u.condition != nil {
+		setBuildCondition(build, *u.condition)
+	}
+	if u.ownerReferenceRetries != nil {
+		setAnnotationInt32(build, ownerReferenceRetriesAnnotation, *u.ownerReferenceRetries)
+	}
+	if u.crashRebuildCount != nil {
+		setAnnotationInt32(build, crashRebuildCountAnnotation, *u.crashRebuildCount)
+	}
+	if u.inputImageDigestsJSON != nil {
+		if build.Annotations == nil {
+			build.Annotations = map[string]string{}
+		}
+		build.Annotations[inputImageDigestsAnnotation] = *u.inputImageDigestsJSON
+	}
+}
+
+// ownerReferenceRetriesAnnotation and crashRebuildCountAnnotation record
+// controller bookkeeping counters that, unlike build.Status.Phase/Reason/...,
+// have no home on the Build API today, the same way buildPodNameAnnotation
+// does for the build's pod name.
+const (
+	ownerReferenceRetriesAnnotation = "build.openshift.io/owner-reference-retries"
+	crashRebuildCountAnnotation     = "build.openshift.io/crash-rebuild-count"
+)
+
+// inputImageDigestsAnnotation records the JSON-encoded spec-path-to-digest
+// map staged by setInputImageDigests, the same way ownerReferenceRetries and
+// crashRebuildCount are recorded via an annotation rather than a new Status
+// field: the real buildv1.Build API has no home for it.
+const inputImageDigestsAnnotation = "build.openshift.io/input-image-digests"
+
+// ownerReferenceRetries reads back the count staged by
+// setOwnerReferenceRetries via ownerReferenceRetriesAnnotation, defaulting to
+// 0 for a build that has never retried.
+func ownerReferenceRetries(build *buildv1.Build) int32 {
+	return annotationInt32(build, ownerReferenceRetriesAnnotation)
+}
+
+// crashRebuildCount reads back the count staged by setCrashRebuildCount via
+// crashRebuildCountAnnotation, defaulting to 0 for a build whose pod has
+// never been rebuilt after a crash.
+func crashRebuildCount(build *buildv1.Build) int32 {
+	return annotationInt32(build, crashRebuildCountAnnotation)
+}
+
+func annotationInt32(build *buildv1.Build, key string) int32 {
+	v, ok := build.Annotations[key]
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(v, 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(n)
+}
+
+func setAnnotationInt32(build *buildv1.Build, key string, n int32) {
+	if build.Annotations == nil {
+		build.Annotations = map[string]string{}
+	}
+	build.Annotations[key] = strconv.FormatInt(int64(n), 10)
+}
+
+// setBuildCondition upserts condition into build.Status.Conditions by Type,
+// replacing any existing entry of the same type rather than appending a
+// duplicate, the same "last observation wins" semantics as the rest of
+// buildUpdate.apply.
+func setBuildCondition(build *buildv1.Build, condition buildv1.BuildCondition) {
+	for i := range build.Status.Conditions {
+		if build.Status.Conditions[i].Type == condition.Type {
+			build.Status.Conditions[i] = condition
+			return
+		}
+	}
+	build.Status.Conditions = append(build.Status.Conditions, condition)
+}
+
+// buildPodNameAnnotation records the name of the pod created for a build, so
+// a later sync can find it again even if the deterministic name derivation
+// ever changes.
+const buildPodNameAnnotation = "build.openshift.io/build-pod-name"
@@ -0,0 +1,93 @@
+// Package defaults applies cluster-wide default configuration to build
+// pods, by way of BuildDefaults.ApplyDefaults.
+package defaults
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// helperVolumeName is the EmptyDir shared by the main build container and
+// any injected helper containers, so a helper (an artifact uploader, a log
+// shipper) can pick up files the main container wrote before exiting.
+const helperVolumeName = "helper"
+
+// helperVolumeMountPath is where helperVolumeName is mounted in every
+// container that participates in the build pod.
+const helperVolumeMountPath = "/var/run/build-helper"
+
+// helperContainerPrefix mirrors build_controller.go's isHelperContainer
+// naming convention, so containers injected here are recognized as helpers
+// by the controller's completion/failure handling.
+const helperContainerPrefix = "helper-"
+
+// BuildDefaults are cluster-wide defaults the build controller applies to
+// every build and its build pod.
+type BuildDefaults struct {
+	// DefaultBuildTimeout bounds how long a build may run after its pod
+	// starts before enforceDeadline fails it. When the build also sets
+	// Spec.CompletionDeadlineSeconds, the larger of the two is used.
+	DefaultBuildTimeout time.Duration
+
+	// PendingDeadline bounds how long a build's pod may remain Pending
+	// before enforceDeadline fails it.
+	PendingDeadline time.Duration
+
+	// RebuildOnCrash opts every build into maybeRebuildOnCrash's
+	// crash-recovery path, unless overridden per-build via the
+	// rebuild-on-crash annotation.
+	RebuildOnCrash bool
+
+	// HelperContainers are appended to every build pod, named with
+	// helperContainerPrefix so the build controller waits for them to
+	// terminate before completing the build (see helperContainersDone) and,
+	// if build.openshift.io/helper-required is set, fails the build if one
+	// of them exits non-zero.
+	HelperContainers []corev1.Container
+}
+
+// ApplyDefaults injects the configured helper containers into pod, giving
+// each one and the main build container access to a shared EmptyDir volume.
+func (d BuildDefaults) ApplyDefaults(pod *corev1.Pod) error {
+	for _, c := range d.HelperContainers {
+		addHelperContainer(pod, c)
+	}
+	return nil
+}
+
+// addHelperContainer appends c to pod as a helper container: it is renamed
+// with helperContainerPrefix if it doesn't already have it, and it and the
+// main build container (pod.Spec.Containers[0]) are given a mount for the
+// shared helper EmptyDir volume.
+func addHelperContainer(pod *corev1.Pod, c corev1.Container) {
+	ensureHelperVolume(pod)
+
+	if len(c.Name) == 0 {
+		c.Name = helperContainerPrefix
+	} else if !hasHelperPrefix(c.Name) {
+		c.Name = helperContainerPrefix + c.Name
+	}
+	c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: helperVolumeName, MountPath: helperVolumeMountPath})
+
+	if len(pod.Spec.Containers) > 0 {
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: helperVolumeName, MountPath: helperVolumeMountPath})
+	}
+	pod.Spec.Containers = append(pod.Spec.Containers, c)
+}
+
+func hasHelperPrefix(name string) bool {
+	return len(name) >= len(helperContainerPrefix) && name[:len(helperContainerPrefix)] == helperContainerPrefix
+}
+
+func ensureHelperVolume(pod *corev1.Pod) {
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == helperVolumeName {
+			return
+		}
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name:         helperVolumeName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+}
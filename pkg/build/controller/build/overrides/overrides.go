@@ -0,0 +1,67 @@
+// Package overrides applies cluster-wide overrides to build pods, by way of
+// BuildOverrides.ApplyOverrides. It runs after defaults.BuildDefaults.ApplyDefaults,
+// so it can add to what the defaults already injected.
+package overrides
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// helperVolumeName must match defaults.helperVolumeName: both packages may
+// add helper containers to the same pod, and they need to share one
+// EmptyDir rather than mount two.
+const helperVolumeName = "helper"
+
+const helperVolumeMountPath = "/var/run/build-helper"
+
+const helperContainerPrefix = "helper-"
+
+// BuildOverrides are cluster-wide settings that take precedence over both
+// the Build's own spec and BuildDefaults.
+type BuildOverrides struct {
+	// HelperContainers are appended to every build pod, after any
+	// BuildDefaults.HelperContainers, named with helperContainerPrefix so
+	// the build controller waits for them to terminate before completing
+	// the build.
+	HelperContainers []corev1.Container
+}
+
+// ApplyOverrides injects the configured helper containers into pod.
+func (o BuildOverrides) ApplyOverrides(pod *corev1.Pod) error {
+	for _, c := range o.HelperContainers {
+		addHelperContainer(pod, c)
+	}
+	return nil
+}
+
+func addHelperContainer(pod *corev1.Pod, c corev1.Container) {
+	ensureHelperVolume(pod)
+
+	if len(c.Name) == 0 {
+		c.Name = helperContainerPrefix
+	} else if !hasHelperPrefix(c.Name) {
+		c.Name = helperContainerPrefix + c.Name
+	}
+	c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: helperVolumeName, MountPath: helperVolumeMountPath})
+
+	if len(pod.Spec.Containers) > 0 {
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: helperVolumeName, MountPath: helperVolumeMountPath})
+	}
+	pod.Spec.Containers = append(pod.Spec.Containers, c)
+}
+
+func hasHelperPrefix(name string) bool {
+	return len(name) >= len(helperContainerPrefix) && name[:len(helperContainerPrefix)] == helperContainerPrefix
+}
+
+func ensureHelperVolume(pod *corev1.Pod) {
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == helperVolumeName {
+			return
+		}
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name:         helperVolumeName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+}
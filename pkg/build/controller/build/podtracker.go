@@ -0,0 +1,241 @@
+package build
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeinformers "k8s.io/client-go/informers/core/v1"
+	v1lister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	buildv1 "github.com/openshift/api/build/v1"
+)
+
+// maxTrackedPodEvents is the number of most-recent events EventsForPod
+// returns for a pod. classifyFatalPodEvents inspects all of them;
+// mergePodEventsIntoBuild records only the latest one on the build.
+const maxTrackedPodEvents = 5
+
+// podEventRequeueRateLimit bounds how often a single build can be re-enqueued
+// as a result of events observed on its pod, so a noisy pod cannot flood the
+// buildQueue.
+const podEventRequeueRateLimit = 5 * time.Second
+
+// PodTracker watches Kubernetes Events in a build pod's namespace and
+// correlates them to the tracked build pod so the build controller can
+// surface failures such as ErrImagePull, FailedScheduling, OOMKilled or
+// FailedMount on the Build well before the pod itself reaches a terminal
+// phase.
+type PodTracker struct {
+	podLister v1lister.PodLister
+
+	eventInformer cache.SharedIndexInformer
+	eventLister   v1lister.EventLister
+
+	eventStoreSynced func() bool
+	podStoreSynced   func() bool
+
+	buildQueue workqueue.RateLimitingInterface
+
+	lock         sync.Mutex
+	lastEnqueued map[string]time.Time
+}
+
+// NewPodTracker creates a PodTracker that watches the given Event informer
+// and enqueues builds onto buildQueue when a tracked pod's events change.
+// eventInformer may be nil if the caller hasn't wired one up yet; in that
+// case the tracker degrades gracefully and EventsForPod always returns no
+// events, rather than panicking when the informer is dereferenced.
+func NewPodTracker(eventInformer kubeinformers.EventInformer, podLister v1lister.PodLister, podStoreSynced func() bool, buildQueue workqueue.RateLimitingInterface) *PodTracker {
+	pt := &PodTracker{
+		podLister:      podLister,
+		podStoreSynced: podStoreSynced,
+		buildQueue:     buildQueue,
+		lastEnqueued:   make(map[string]time.Time),
+	}
+	if eventInformer == nil {
+		glog.V(2).Infof("PodTracker started without an Event informer; pod event correlation is disabled")
+		pt.eventStoreSynced = func() bool { return true }
+		return pt
+	}
+	pt.eventInformer = eventInformer.Informer()
+	pt.eventLister = eventInformer.Lister()
+	pt.eventStoreSynced = pt.eventInformer.HasSynced
+	pt.eventInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    pt.eventAdded,
+		UpdateFunc: pt.eventUpdated,
+	})
+	return pt
+}
+
+// WaitForCacheSync blocks until the Event cache (and the Pod cache it
+// correlates against) have synced, or stopCh is closed.
+func (pt *PodTracker) WaitForCacheSync(stopCh <-chan struct{}) bool {
+	return cache.WaitForCacheSync(stopCh, pt.eventStoreSynced, pt.podStoreSynced)
+}
+
+func (pt *PodTracker) eventAdded(obj interface{}) {
+	pt.handleEvent(obj.(*corev1.Event))
+}
+
+func (pt *PodTracker) eventUpdated(old, cur interface{}) {
+	pt.handleEvent(cur.(*corev1.Event))
+}
+
+// handleEvent re-enqueues the build that owns the pod named by the event's
+// InvolvedObject, subject to podEventRequeueRateLimit.
+func (pt *PodTracker) handleEvent(event *corev1.Event) {
+	if event.InvolvedObject.Kind != "Pod" {
+		return
+	}
+	pod, err := pt.podLister.Pods(event.Namespace).Get(event.InvolvedObject.Name)
+	if err != nil {
+		return
+	}
+	buildName := getBuildName(pod)
+	if len(buildName) == 0 {
+		return
+	}
+	key := resourceName(event.Namespace, buildName)
+
+	pt.lock.Lock()
+	last, seen := pt.lastEnqueued[key]
+	if seen && time.Since(last) < podEventRequeueRateLimit {
+		pt.lock.Unlock()
+		return
+	}
+	pt.lastEnqueued[key] = time.Now()
+	pt.lock.Unlock()
+
+	glog.V(4).Infof("PodTracker observed event %q for pod %s, re-enqueuing build %s", event.Reason, event.InvolvedObject.Name, key)
+	pt.buildQueue.AddRateLimited(key)
+}
+
+// EventsForPod returns, in chronological order, the events the tracker has
+// observed that are involved with the given pod, up to maxTrackedPodEvents.
+func (pt *PodTracker) EventsForPod(pod *corev1.Pod) ([]*corev1.Event, error) {
+	if pt.eventLister == nil {
+		return nil, nil
+	}
+	events, err := pt.eventLister.Events(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+	var matched []*corev1.Event
+	for _, e := range events {
+		if e.InvolvedObject.Name == pod.Name && e.InvolvedObject.Kind == "Pod" {
+			matched = append(matched, e)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].LastTimestamp.Time.Before(matched[j].LastTimestamp.Time)
+	})
+	if len(matched) > maxTrackedPodEvents {
+		matched = matched[len(matched)-maxTrackedPodEvents:]
+	}
+	return matched, nil
+}
+
+// statusReasonPullBuildImageFailed is staged on a pending build whose pod's
+// most recent event is a Warning, as a coarse "something is wrong pulling or
+// starting this pod" signal ahead of classifyFatalPodEvents' more specific
+// (and retry/grace-period gated) reasons.
+const statusReasonPullBuildImageFailed buildv1.StatusReason = "PullBuildImageFailed"
+
+// buildConditionPodEvent is the Conditions entry type mergePodEventsIntoBuild
+// upserts to summarize the most recent pod event, independent of whatever
+// Reason/Message is staged for the build itself.
+const buildConditionPodEvent buildv1.BuildConditionType = "PodEvent"
+
+// imagePullRetryThreshold is how many times an ErrImagePull/ImagePullBackOff
+// warning must recur on a pod before the build is considered unrecoverable.
+const imagePullRetryThreshold = 5
+
+// schedulingFailureGracePeriod is how long a FailedScheduling warning may
+// persist before the build is failed outright, to allow for transient
+// autoscaler/bin-packing churn.
+const schedulingFailureGracePeriod = 2 * time.Minute
+
+// statusReasonImagePullFailed, statusReasonPodSchedulingFailed and
+// statusReasonQuotaDenied are the terminal reasons classifyFatalPodEvents
+// stages, declared locally rather than on buildv1.StatusReason since the
+// real API type doesn't define them.
+const (
+	statusReasonImagePullFailed     buildv1.StatusReason = "ImagePullFailed"
+	statusReasonPodSchedulingFailed buildv1.StatusReason = "PodSchedulingFailed"
+	statusReasonQuotaDenied         buildv1.StatusReason = "QuotaDenied"
+)
+
+// classifyFatalPodEvents inspects a pod's recent Warning events and decides
+// whether they indicate an unrecoverable failure (image pull, scheduling or
+// quota denial) that should fail the build now rather than waiting for the
+// pod to reach a terminal phase on its own.
+func classifyFatalPodEvents(events []*corev1.Event) (buildv1.StatusReason, string, bool) {
+	for _, e := range events {
+		if e.Type != corev1.EventTypeWarning {
+			continue
+		}
+		switch e.Reason {
+		case "ErrImagePull", "ImagePullBackOff":
+			if e.Count >= imagePullRetryThreshold {
+				return statusReasonImagePullFailed, e.Message, true
+			}
+		case "FailedScheduling":
+			if time.Since(e.FirstTimestamp.Time) >= schedulingFailureGracePeriod {
+				return statusReasonPodSchedulingFailed, e.Message, true
+			}
+		case "FailedCreate":
+			if strings.Contains(e.Message, "exceeded quota") {
+				return statusReasonQuotaDenied, e.Message, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// mergePodEventsIntoBuild records a condition summarizing the latest of the
+// given pod events on the update; earlier events in the slice are not
+// recorded. If the pod is still Pending and that latest event is a Warning,
+// it also uses it to populate the build's Reason/Message and
+// LogSnippet so users have some signal before the pod produces any container
+// output. Benign Normal events (Scheduled, Pulling, Pulled, ...), which the
+// scheduler and kubelet emit for essentially every pod, are recorded in the
+// condition but never stamped onto Reason/Message -- only a Warning
+// indicates something is actually wrong.
+func mergePodEventsIntoBuild(pod *corev1.Pod, events []*corev1.Event, update *buildUpdate) {
+	if len(events) == 0 {
+		return
+	}
+	last := events[len(events)-1]
+	msg := fmt.Sprintf("%s: %s", last.Reason, last.Message)
+	if len(msg) > 120 {
+		msg = msg[:117] + "..."
+	}
+
+	conditionStatus := corev1.ConditionFalse
+	if last.Type == corev1.EventTypeWarning {
+		conditionStatus = corev1.ConditionTrue
+	}
+	update.setCondition(buildv1.BuildCondition{
+		Type:               buildConditionPodEvent,
+		Status:             conditionStatus,
+		LastTransitionTime: metav1.Now(),
+		Reason:             last.Reason,
+		Message:            last.Message,
+	})
+
+	if pod.Status.Phase == corev1.PodPending && last.Type == corev1.EventTypeWarning {
+		update.setLogSnippet(msg)
+		update.setReason(statusReasonPullBuildImageFailed)
+		update.setMessage(msg)
+	}
+}
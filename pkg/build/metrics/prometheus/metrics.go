@@ -0,0 +1,61 @@
+// Package prometheus registers the build controller's Prometheus metrics.
+package prometheus
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	buildv1lister "github.com/openshift/client-go/build/listers/build/v1"
+)
+
+const buildSubsystem = "openshift_build"
+
+var (
+	// BuildUpdateConflictRetries counts how many times a build status patch
+	// hit a resourceVersion conflict and was retried against a freshly
+	// fetched build, via PatchWithRetry.
+	BuildUpdateConflictRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: buildSubsystem,
+		Name:      "update_conflict_retries_total",
+		Help:      "Counts the number of times a build status update was retried after a resourceVersion conflict.",
+	})
+
+	// BuildTimeoutsTotal counts builds failed by enforceDeadline, broken down
+	// by which deadline (PendingDeadlineExceeded or DeadlineExceeded) fired.
+	BuildTimeoutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: buildSubsystem,
+		Name:      "timeouts_total",
+		Help:      "Counts builds failed for exceeding their pending or completion deadline, by reason.",
+	}, []string{"reason"})
+
+	// BuildCrashRebuildsTotal counts how many times maybeRebuildOnCrash has
+	// recreated a crashed build pod.
+	BuildCrashRebuildsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: buildSubsystem,
+		Name:      "crash_rebuilds_total",
+		Help:      "Counts the number of times a crashed build pod was recreated.",
+	})
+
+	// BuildImageResolutionTimeoutsTotal counts builds whose input image
+	// reference resolution was abandoned after bc.imageResolutionTimeout.
+	BuildImageResolutionTimeoutsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: buildSubsystem,
+		Name:      "image_resolution_timeouts_total",
+		Help:      "Counts builds whose input image reference resolution did not complete before the configured timeout.",
+	})
+
+	registerMetrics sync.Once
+)
+
+// IntializeMetricsCollector registers the build controller's collectors with
+// the legacy Prometheus registry. lister is accepted so future collectors
+// can derive gauges (e.g. builds by phase) directly from the build cache.
+func IntializeMetricsCollector(lister buildv1lister.BuildLister) {
+	registerMetrics.Do(func() {
+		prometheus.MustRegister(BuildUpdateConflictRetries)
+		prometheus.MustRegister(BuildTimeoutsTotal)
+		prometheus.MustRegister(BuildCrashRebuildsTotal)
+		prometheus.MustRegister(BuildImageResolutionTimeoutsTotal)
+	})
+}
@@ -0,0 +1,167 @@
+package buildkit
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	buildv1 "github.com/openshift/api/build/v1"
+	"github.com/openshift/origin/pkg/build/buildapihelpers"
+	buildutil "github.com/openshift/origin/pkg/build/util"
+)
+
+const (
+	// buildKitContainerName is the name of the container in the build pod
+	// that runs buildkitd and the buildctl frontend invocation.
+	buildKitContainerName = "buildkit"
+
+	// buildKitConfigKey is the key under which buildkitd.toml is stored in
+	// the ConfigMap mounted next to the CA bundle configMap produced by
+	// createBuildCAConfigMap.
+	buildKitConfigKey = "buildkitd.toml"
+
+	buildKitConfigMountPath = "/etc/buildkit"
+	buildKitRunDir          = "/run/buildkit"
+
+	// buildKitHostEnvVar points the buildctl invocation at the buildkitd
+	// socket running in the same pod.
+	buildKitHostEnvVar = "BUILDKIT_HOST"
+)
+
+// BuildKitStrategy creates a build pod that runs buildkitd and a buildctl
+// frontend invocation in the same pod, in place of the privileged docker
+// socket path used by the Docker/Source/Custom strategies. Pods produced by
+// this strategy do not request SecurityContext.Privileged and are intended
+// to run under a user-namespace/rootless OCI worker configuration.
+type BuildKitStrategy struct {
+	// Image is the buildkitd image used for both the buildkitd daemon and
+	// the buildctl frontend invocation in the build pod.
+	Image string
+}
+
+// NewBuildKitStrategy creates a BuildKitStrategy that runs buildkitd using
+// the given image.
+func NewBuildKitStrategy(image string) *BuildKitStrategy {
+	return &BuildKitStrategy{Image: image}
+}
+
+// CreateBuildPod creates the pod to be used for the BuildKit build.
+// includeAdditionalCA mirrors the other strategies: when true, the pod
+// mounts the build's CA configMap (created separately by the build
+// controller via createBuildCAConfigMap) alongside the buildkitd.toml
+// configMap named "<build pod name>-buildkitd", which it mounts at
+// buildKitConfigMountPath. CreateBuildPod does not create that configMap.
+func (s *BuildKitStrategy) CreateBuildPod(build *buildv1.Build, includeAdditionalCA bool) (*corev1.Pod, error) {
+	opts := build.Spec.Strategy.BuildKitStrategy
+	if opts == nil {
+		return nil, fmt.Errorf("build %s/%s does not specify a BuildKitStrategy", build.Namespace, build.Name)
+	}
+
+	container := corev1.Container{
+		Name:            buildKitContainerName,
+		Image:           s.Image,
+		Args:            buildctlArgs(build, opts),
+		SecurityContext: &corev1.SecurityContext{Privileged: boolPtr(false)},
+		Env:             setupBuildKitEnv(build, opts),
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "buildkitd-config", MountPath: buildKitConfigMountPath},
+			{Name: "buildkitd-run", MountPath: buildKitRunDir},
+		},
+	}
+	if includeAdditionalCA {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "build-ca",
+			MountPath: buildutil.CADirMountPath,
+		})
+	}
+
+	volumes := []corev1.Volume{
+		{
+			Name: "buildkitd-config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: buildapihelpers.GetBuildPodName(build) + "-buildkitd"},
+				},
+			},
+		},
+		{
+			Name:         "buildkitd-run",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+	}
+	if includeAdditionalCA {
+		volumes = append(volumes, corev1.Volume{
+			Name: "build-ca",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: buildapihelpers.GetBuildCAConfigMapName(build)},
+				},
+			},
+		})
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      buildapihelpers.GetBuildPodName(build),
+			Namespace: build.Namespace,
+			Labels: map[string]string{
+				buildutil.BuildLabel: build.Name,
+			},
+			Annotations: map[string]string{
+				buildutil.BuildAnnotation: build.Name,
+			},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers:    []corev1.Container{container},
+			Volumes:       volumes,
+		},
+	}
+	return pod, nil
+}
+
+// buildctlArgs assembles the buildctl/buildkitd argument list for the given
+// build and BuildKit strategy options: frontend selection, cache
+// import/export refs and --opt build-args.
+func buildctlArgs(build *buildv1.Build, opts *buildv1.BuildKitStrategyOptions) []string {
+	args := []string{
+		"build",
+		"--frontend", opts.Frontend,
+		"--oci-worker", "true",
+		"--oci-worker-rootless",
+	}
+	for _, ref := range opts.CacheImportRefs {
+		args = append(args, "--import-cache", fmt.Sprintf("type=registry,ref=%s", ref))
+	}
+	for _, ref := range opts.CacheExportRefs {
+		args = append(args, "--export-cache", fmt.Sprintf("type=registry,ref=%s,mode=max", ref))
+	}
+	for k, v := range opts.BuildArgs {
+		args = append(args, "--opt", fmt.Sprintf("build-arg:%s=%s", k, v))
+	}
+	return args
+}
+
+// setupBuildKitEnv builds the environment for the buildkit container: the
+// socket address the buildctl invocation should use and, when the build has
+// a push secret, that secret's name in BUILDKIT_REGISTRY_SECRET. It does not
+// add the credentials themselves or the additional trusted CA bundle to the
+// environment; the CA bundle reaches the pod only through the build-ca
+// volume CreateBuildPod mounts when includeAdditionalCA is true.
+func setupBuildKitEnv(build *buildv1.Build, opts *buildv1.BuildKitStrategyOptions) []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{Name: buildKitHostEnvVar, Value: "unix://" + buildKitRunDir + "/buildkitd.sock"},
+	}
+	if build.Spec.Output.PushSecret != nil {
+		env = append(env, corev1.EnvVar{
+			Name:  "BUILDKIT_REGISTRY_SECRET",
+			Value: build.Spec.Output.PushSecret.Name,
+		})
+	}
+	return env
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
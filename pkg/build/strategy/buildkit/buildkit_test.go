@@ -0,0 +1,88 @@
+package buildkit
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	buildv1 "github.com/openshift/api/build/v1"
+)
+
+// TestBuildctlArgsCacheImportExport verifies that registry cache import and
+// export refs are translated into the --import-cache/--export-cache flags
+// buildctl expects, in the order they were configured.
+func TestBuildctlArgsCacheImportExport(t *testing.T) {
+	build := &buildv1.Build{}
+	opts := &buildv1.BuildKitStrategyOptions{
+		Frontend:        "dockerfile.v0",
+		CacheImportRefs: []string{"registry.example.com/cache:import"},
+		CacheExportRefs: []string{"registry.example.com/cache:export"},
+	}
+
+	args := buildctlArgs(build, opts)
+
+	want := []string{
+		"build",
+		"--frontend", "dockerfile.v0",
+		"--oci-worker", "true",
+		"--oci-worker-rootless",
+		"--import-cache", "type=registry,ref=registry.example.com/cache:import",
+		"--export-cache", "type=registry,ref=registry.example.com/cache:export,mode=max",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("buildctlArgs() = %v, want %v", args, want)
+	}
+}
+
+// TestBuildctlArgsNoCache verifies that omitting cache refs produces no
+// --import-cache/--export-cache flags at all, rather than empty ones.
+func TestBuildctlArgsNoCache(t *testing.T) {
+	build := &buildv1.Build{}
+	opts := &buildv1.BuildKitStrategyOptions{Frontend: "dockerfile.v0"}
+
+	args := buildctlArgs(build, opts)
+
+	for _, a := range args {
+		if a == "--import-cache" || a == "--export-cache" {
+			t.Fatalf("buildctlArgs() unexpectedly included %q with no cache refs configured: %v", a, args)
+		}
+	}
+}
+
+// TestCreateBuildPodIncludeAdditionalCAHasMatchingVolume verifies that every
+// VolumeMount CreateBuildPod adds to the buildkit container has a
+// corresponding entry in pod.Spec.Volumes, including "build-ca" when
+// includeAdditionalCA is true -- a pod that fails this is rejected outright
+// by the API server with a "volumeMounts[x].name: Not found" error.
+func TestCreateBuildPodIncludeAdditionalCAHasMatchingVolume(t *testing.T) {
+	build := &buildv1.Build{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "build-1"},
+		Spec: buildv1.BuildSpec{
+			Strategy: buildv1.BuildStrategy{
+				BuildKitStrategy: &buildv1.BuildKitStrategyOptions{Frontend: "dockerfile.v0"},
+			},
+		},
+	}
+	s := NewBuildKitStrategy("buildkit:latest")
+
+	pod, err := s.CreateBuildPod(build, true)
+	if err != nil {
+		t.Fatalf("CreateBuildPod() returned error: %v", err)
+	}
+
+	volumes := make(map[string]bool)
+	for _, v := range pod.Spec.Volumes {
+		volumes[v.Name] = true
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, m := range c.VolumeMounts {
+			if !volumes[m.Name] {
+				t.Errorf("container %q mounts volume %q, but pod.Spec.Volumes has no such entry", c.Name, m.Name)
+			}
+		}
+	}
+	if !volumes["build-ca"] {
+		t.Errorf("expected a build-ca volume when includeAdditionalCA is true, got volumes %v", pod.Spec.Volumes)
+	}
+}